@@ -0,0 +1,80 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gravitational/teleport"
+	"github.com/gravitational/teleport/lib/backend"
+
+	"github.com/gravitational/trace"
+)
+
+// sessionsBucket is the lib/backend path web sessions are stored
+// under, so a shared etcd/BoltDB backend can host other Teleport
+// state alongside session data without key collisions.
+var sessionsBucket = []string{"web", "sessions"}
+
+// backendSessionStore is a SessionStore backed by lib/backend, making
+// it HA-safe: any proxy pointed at the same backend (etcd, BoltDB,
+// ...) sees the same set of active sessions, so a user's cookie
+// survives a restart or a failover to a different proxy.
+type backendSessionStore struct {
+	bk backend.Backend
+}
+
+// newBackendSessionStore returns a SessionStore sharing sessions
+// through bk.
+func newBackendSessionStore(bk backend.Backend) *backendSessionStore {
+	return &backendSessionStore{bk: bk}
+}
+
+func (s *backendSessionStore) Get(key string) (StoredSession, bool, error) {
+	bytes, err := s.bk.GetVal(sessionsBucket, key)
+	if err != nil {
+		if teleport.IsNotFound(err) {
+			return StoredSession{}, false, nil
+		}
+		return StoredSession{}, false, trace.Wrap(err)
+	}
+	var sess StoredSession
+	if err := json.Unmarshal(bytes, &sess); err != nil {
+		return StoredSession{}, false, trace.Wrap(err)
+	}
+	return sess, true, nil
+}
+
+func (s *backendSessionStore) Set(key string, sess StoredSession, ttl time.Duration) error {
+	bytes, err := json.Marshal(sess)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(s.bk.UpsertVal(sessionsBucket, key, bytes, ttl))
+}
+
+func (s *backendSessionStore) Delete(key string) error {
+	return trace.Wrap(s.bk.DeleteKey(sessionsBucket, key))
+}
+
+// OnExpire is a no-op: lib/backend implementations expire keys
+// passively (on the next read) rather than pushing notifications, so
+// there is nothing to subscribe to. A process that needs to react to
+// expiry should poll Get instead.
+func (s *backendSessionStore) OnExpire(fn func(key string)) {
+}
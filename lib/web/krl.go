@@ -0,0 +1,111 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gravitational/teleport"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+)
+
+// krlAdminClient is the subset of auth.ClientI the revocation
+// subsystem needs from the proxy's own connection to the auth server.
+// It is kept narrow (rather than taking a full auth.ClientI) so it is
+// easy to fake in tests.
+//
+// Building the actual OpenSSH KRL wire format and holding the CA
+// signing key both belong to the auth server, not the proxy, so this
+// package only ever sees the finished, signed bytes; see
+// TestRevokedCertRejectedBySSHKeygen in krl_test.go for a fake that
+// produces those bytes the same way the real auth server will, by
+// shelling out to ssh-keygen.
+type krlAdminClient interface {
+	// RevokeCerts appends (ca, serial, revokedAt) tuples to the auth
+	// server's persistent revocation store for each of serials issued
+	// to user. serials are the values GetCertificate returned as
+	// SSHLoginResponse.CertSerial when the certs were issued.
+	RevokeCerts(user string, serials []uint64) error
+	// GetKRL returns the current OpenSSH key revocation list, signed
+	// by the host CA, covering every certificate revoked so far.
+	GetKRL() ([]byte, error)
+}
+
+// krlCache holds the most recently fetched KRL blob so that
+// GET /v1/webapi/krl can be served without round-tripping to the auth
+// server on every request.
+type krlCache struct {
+	sync.Mutex
+	blob      []byte
+	fetchedAt time.Time
+}
+
+// RevokeUserCerts asks the auth server to revoke the certificates
+// identified by serials that were issued to user, then refreshes this
+// proxy's cached KRL so a concurrent GET of /v1/webapi/krl reflects
+// the revocation immediately instead of waiting for the next poll.
+func (s *sessionCache) RevokeUserCerts(user string, serials []uint64) error {
+	if s.adminClt == nil {
+		return trace.Wrap(teleport.BadParameter("RevokeUserCerts requires an admin client"))
+	}
+	if err := s.adminClt.RevokeCerts(user, serials); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(s.refreshKRL())
+}
+
+// refreshKRL fetches the latest signed KRL from the auth server and
+// stores it in the local cache.
+func (s *sessionCache) refreshKRL() error {
+	blob, err := s.adminClt.GetKRL()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	s.krl.Lock()
+	s.krl.blob = blob
+	s.krl.fetchedAt = time.Now()
+	s.krl.Unlock()
+	return nil
+}
+
+// newKRLHandler serves the cached KRL, fetching it from the auth
+// server on the first request.
+func newKRLHandler(s *sessionCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.krl.Lock()
+		blob := s.krl.blob
+		s.krl.Unlock()
+		if blob == nil {
+			if err := s.refreshKRL(); err != nil {
+				log.Infof("failed to fetch krl: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			s.krl.Lock()
+			blob = s.krl.blob
+			s.krl.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if _, err := w.Write(blob); err != nil {
+			log.Infof("failed to write krl response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gravitational/trace"
+)
+
+// PollKRL fetches proxyAddr+"/v1/webapi/krl" every interval and
+// atomically rewrites path with the result, so a node can point
+// sshd's `RevokedKeys` directive at path and pick up new revocations
+// without a restart. If the first fetch fails it returns that error
+// at once; otherwise it blocks until stop is closed, logging (not
+// returning) any later failed refresh.
+func PollKRL(proxyAddr string, path string, interval time.Duration, stop <-chan struct{}) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	if err := fetchKRLOnce(proxyAddr, path); err != nil {
+		return trace.Wrap(err)
+	}
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if err := fetchKRLOnce(proxyAddr, path); err != nil {
+				log.Infof("failed to refresh krl from %v: %v", proxyAddr, err)
+			}
+		}
+	}
+}
+
+// fetchKRLOnce downloads the KRL and replaces path with it via a
+// rename, so a concurrent sshd read never sees a partial file.
+func fetchKRLOnce(proxyAddr string, path string) error {
+	resp, err := http.Get(proxyAddr + "/v1/webapi/krl")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return trace.Errorf("krl fetch from %v failed with status %v", proxyAddr, resp.StatusCode)
+	}
+	blob, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0644); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(os.Rename(tmp, filepath.Clean(path)))
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeAdminClient is a minimal krlAdminClient that records revoked
+// serials and hands back a KRL blob that changes whenever the set of
+// revoked serials changes, standing in for the real auth server in
+// tests that only care about this package's own cache bookkeeping.
+type fakeAdminClient struct {
+	revoked map[string][]uint64
+	krl     []byte
+}
+
+func newFakeAdminClient() *fakeAdminClient {
+	return &fakeAdminClient{revoked: make(map[string][]uint64)}
+}
+
+func (f *fakeAdminClient) RevokeCerts(user string, serials []uint64) error {
+	f.revoked[user] = append(f.revoked[user], serials...)
+	f.krl = append([]byte{}, []byte(user)...)
+	for _, s := range serials {
+		f.krl = append(f.krl, byte(s))
+	}
+	return nil
+}
+
+func (f *fakeAdminClient) GetKRL() ([]byte, error) {
+	return f.krl, nil
+}
+
+func TestRevokeUserCertsRefreshesKRLCache(t *testing.T) {
+	admin := newFakeAdminClient()
+	s := &sessionCache{adminClt: admin, krl: &krlCache{}}
+
+	if err := s.RevokeUserCerts("alice", []uint64{1, 2}); err != nil {
+		t.Fatalf("RevokeUserCerts: %v", err)
+	}
+
+	s.krl.Lock()
+	cached := append([]byte{}, s.krl.blob...)
+	s.krl.Unlock()
+
+	if !reflect.DeepEqual(cached, admin.krl) {
+		t.Fatalf("cached krl %v does not reflect revocation, want %v", cached, admin.krl)
+	}
+	if !reflect.DeepEqual(admin.revoked["alice"], []uint64{1, 2}) {
+		t.Fatalf("unexpected revoked serials for alice: %v", admin.revoked["alice"])
+	}
+}
+
+func TestKRLHandlerServesCachedBlob(t *testing.T) {
+	admin := newFakeAdminClient()
+	admin.krl = []byte("initial-krl")
+	s := &sessionCache{adminClt: admin, krl: &krlCache{}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/v1/webapi/krl", nil)
+	newKRLHandler(s)(w, r)
+
+	if got := w.Body.String(); got != "initial-krl" {
+		t.Fatalf("handler returned %q, want %q", got, "initial-krl")
+	}
+}
+
+// shellKRLAdminClient is a krlAdminClient backed by the real
+// ssh-keygen binary. It owns its own OpenSSH CA keypair and, on every
+// RevokeCerts call, regenerates a real OpenSSH KRL covering all
+// serials revoked so far via `ssh-keygen -k`, the same tool and wire
+// format the auth server will use. This lets tests drive the exact
+// mechanics sshd itself relies on instead of an opaque stand-in.
+type shellKRLAdminClient struct {
+	dir     string
+	caKey   string
+	caPub   string
+	krlPath string
+	revoked []uint64
+}
+
+func newShellKRLAdminClient(t *testing.T, dir string) *shellKRLAdminClient {
+	t.Helper()
+	caKey := filepath.Join(dir, "ca")
+	runSSHKeygen(t, "-t", "ed25519", "-f", caKey, "-N", "", "-q")
+	return &shellKRLAdminClient{
+		dir:     dir,
+		caKey:   caKey,
+		caPub:   caKey + ".pub",
+		krlPath: filepath.Join(dir, "revoked.krl"),
+	}
+}
+
+func (c *shellKRLAdminClient) RevokeCerts(user string, serials []uint64) error {
+	c.revoked = append(c.revoked, serials...)
+	spec := filepath.Join(c.dir, "revoked.spec")
+	var lines strings.Builder
+	for _, serial := range c.revoked {
+		fmt.Fprintf(&lines, "serial: %d\n", serial)
+	}
+	if err := ioutil.WriteFile(spec, []byte(lines.String()), 0600); err != nil {
+		return err
+	}
+	// without -u, ssh-keygen -k writes a brand-new KRL rather than
+	// adding to the existing one, so the spec lists every serial
+	// revoked so far and the old file is removed before regenerating.
+	os.Remove(c.krlPath)
+	out, err := exec.Command("ssh-keygen", "-k", "-f", c.krlPath, "-s", c.caPub, spec).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ssh-keygen -k: %v: %s", err, out)
+	}
+	return nil
+}
+
+func (c *shellKRLAdminClient) GetKRL() ([]byte, error) {
+	return ioutil.ReadFile(c.krlPath)
+}
+
+// mintCert signs a fresh user keypair with the client's CA under the
+// given serial and returns the path to the resulting cert file, the
+// same serial GetCertificate would have returned as CertSerial.
+func (c *shellKRLAdminClient) mintCert(t *testing.T, identity string, serial uint64) string {
+	t.Helper()
+	keyPath := filepath.Join(c.dir, identity)
+	runSSHKeygen(t, "-t", "ed25519", "-f", keyPath, "-N", "", "-q")
+	runSSHKeygen(t, "-s", c.caKey, "-I", identity, "-n", identity,
+		"-z", strconv.FormatUint(serial, 10), "-V", "+1d", keyPath+".pub")
+	return keyPath + "-cert.pub"
+}
+
+func runSSHKeygen(t *testing.T, args ...string) {
+	t.Helper()
+	out, err := exec.Command("ssh-keygen", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ssh-keygen %v: %v: %s", args, err, out)
+	}
+}
+
+// TestRevokedCertRejectedBySSHKeygen mints a real cert, revokes it
+// through the sessionCache revocation path, regenerates the KRL and
+// checks that ssh-keygen itself -- not just this package's
+// bookkeeping -- considers the cert revoked, while a cert that was
+// never revoked still checks out.
+func TestRevokedCertRejectedBySSHKeygen(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+
+	dir, err := ioutil.TempDir("", "krl-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	admin := newShellKRLAdminClient(t, dir)
+	s := &sessionCache{adminClt: admin, krl: &krlCache{}}
+
+	revokedCert := admin.mintCert(t, "alice", 42)
+	keptCert := admin.mintCert(t, "bob", 43)
+
+	if err := s.RevokeUserCerts("alice", []uint64{42}); err != nil {
+		t.Fatalf("RevokeUserCerts: %v", err)
+	}
+
+	s.krl.Lock()
+	served := append([]byte{}, s.krl.blob...)
+	s.krl.Unlock()
+
+	servedPath := filepath.Join(dir, "served.krl")
+	if err := ioutil.WriteFile(servedPath, served, 0600); err != nil {
+		t.Fatalf("write served krl: %v", err)
+	}
+
+	out, err := exec.Command("ssh-keygen", "-Q", "-f", servedPath, revokedCert).CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected ssh-keygen -Q to reject revoked cert %v, got: %s", revokedCert, out)
+	}
+	if !strings.Contains(string(out), "REVOKED") {
+		t.Fatalf("expected REVOKED verdict for %v, got: %s", revokedCert, out)
+	}
+
+	out, err = exec.Command("ssh-keygen", "-Q", "-f", servedPath, keptCert).CombinedOutput()
+	if err != nil {
+		t.Fatalf("expected ssh-keygen -Q to accept non-revoked cert %v, got: %v: %s", keptCert, err, out)
+	}
+	if strings.Contains(string(out), "REVOKED") {
+		t.Fatalf("non-revoked cert %v was reported revoked: %s", keptCert, out)
+	}
+}
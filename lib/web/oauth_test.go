@@ -0,0 +1,213 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gravitational/teleport/lib/auth"
+	"github.com/mailgun/ttlmap"
+)
+
+func newTestOAuthCache(t *testing.T, p OAuthProvider) *sessionCache {
+	requests, err := ttlmap.NewMap(1024)
+	if err != nil {
+		t.Fatalf("ttlmap.NewMap: %v", err)
+	}
+	return &sessionCache{
+		oauthProviders: map[string]OAuthProvider{p.Name: p},
+		oauthRequests:  requests,
+	}
+}
+
+func TestStartOAuthLoginUnknownProvider(t *testing.T) {
+	s := newTestOAuthCache(t, OAuthProvider{Name: "google"})
+	if _, _, err := s.StartOAuthLogin("github"); err == nil {
+		t.Fatalf("expected error for unknown provider")
+	}
+}
+
+func TestStartOAuthLoginBuildsAuthURL(t *testing.T) {
+	p := OAuthProvider{
+		Name:        "google",
+		AuthURL:     "https://accounts.google.com/o/oauth2/auth",
+		ClientID:    "client-id",
+		RedirectURL: "https://proxy.example.com/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+	s := newTestOAuthCache(t, p)
+
+	authURL, state, err := s.StartOAuthLogin("google")
+	if err != nil {
+		t.Fatalf("StartOAuthLogin: %v", err)
+	}
+	if state == "" {
+		t.Fatalf("expected a non-empty state")
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("url.Parse(%q): %v", authURL, err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != p.ClientID {
+		t.Fatalf("client_id = %q, want %q", q.Get("client_id"), p.ClientID)
+	}
+	if q.Get("state") != state {
+		t.Fatalf("state in URL %q does not match returned state %q", q.Get("state"), state)
+	}
+	if q.Get("nonce") == "" {
+		t.Fatalf("expected a nonce in the auth URL")
+	}
+
+	val, ok := s.oauthRequests.Get(state)
+	if !ok || val == nil {
+		t.Fatalf("expected StartOAuthLogin to persist the pending request under state")
+	}
+	req := val.(*oauthRequest)
+	if req.provider != "google" {
+		t.Fatalf("stored request provider = %q, want %q", req.provider, "google")
+	}
+	if req.nonce != q.Get("nonce") {
+		t.Fatalf("stored nonce %q does not match the one sent to the provider %q", req.nonce, q.Get("nonce"))
+	}
+}
+
+func TestFinishOAuthLoginUnknownState(t *testing.T) {
+	s := newTestOAuthCache(t, OAuthProvider{Name: "google"})
+	if _, _, err := s.FinishOAuthLogin("google", "bogus-state", "code"); err == nil {
+		t.Fatalf("expected error for unknown state")
+	}
+}
+
+func TestFinishOAuthLoginProviderMismatch(t *testing.T) {
+	p := OAuthProvider{Name: "google", AuthURL: "https://example.com/auth"}
+	s := newTestOAuthCache(t, p)
+	s.oauthProviders["github"] = OAuthProvider{Name: "github", AuthURL: "https://example.com/auth"}
+
+	_, state, err := s.StartOAuthLogin("google")
+	if err != nil {
+		t.Fatalf("StartOAuthLogin: %v", err)
+	}
+	if _, _, err := s.FinishOAuthLogin("github", state, "code"); err == nil {
+		t.Fatalf("expected error when provider does not match the one the state was issued for")
+	}
+}
+
+func TestFinishOAuthLoginRejectsReplayedState(t *testing.T) {
+	p := OAuthProvider{Name: "google", AuthURL: "https://example.com/auth"}
+	s := newTestOAuthCache(t, p)
+	s.oauthExchange = func(p OAuthProvider, code, nonce string) (string, *auth.Session, error) {
+		return "alice@example.com", &auth.Session{ID: "sid"}, nil
+	}
+
+	_, state, err := s.StartOAuthLogin("google")
+	if err != nil {
+		t.Fatalf("StartOAuthLogin: %v", err)
+	}
+	if _, _, err := s.FinishOAuthLogin("google", state, "code"); err != nil {
+		t.Fatalf("first FinishOAuthLogin: %v", err)
+	}
+	if _, _, err := s.FinishOAuthLogin("google", state, "code"); err == nil {
+		t.Fatalf("expected second FinishOAuthLogin with the same state to be rejected as a replay")
+	}
+}
+
+func TestFinishOAuthLoginEnforcesAllowedDomains(t *testing.T) {
+	p := OAuthProvider{
+		Name:           "google",
+		AuthURL:        "https://example.com/auth",
+		AllowedDomains: []string{"example.com"},
+	}
+	s := newTestOAuthCache(t, p)
+	s.oauthExchange = func(p OAuthProvider, code, nonce string) (string, *auth.Session, error) {
+		return "mallory@evil.com", &auth.Session{ID: "sid"}, nil
+	}
+
+	_, state, err := s.StartOAuthLogin("google")
+	if err != nil {
+		t.Fatalf("StartOAuthLogin: %v", err)
+	}
+	if _, _, err := s.FinishOAuthLogin("google", state, "code"); err == nil {
+		t.Fatalf("expected identity outside AllowedDomains to be rejected")
+	}
+}
+
+func TestFinishOAuthLoginAcceptsAllowedDomain(t *testing.T) {
+	p := OAuthProvider{
+		Name:           "google",
+		AuthURL:        "https://example.com/auth",
+		AllowedDomains: []string{"example.com"},
+	}
+	s := newTestOAuthCache(t, p)
+	s.oauthExchange = func(p OAuthProvider, code, nonce string) (string, *auth.Session, error) {
+		return "alice@example.com", &auth.Session{ID: "sid"}, nil
+	}
+
+	_, state, err := s.StartOAuthLogin("google")
+	if err != nil {
+		t.Fatalf("StartOAuthLogin: %v", err)
+	}
+	identity, sess, err := s.FinishOAuthLogin("google", state, "code")
+	if err != nil {
+		t.Fatalf("FinishOAuthLogin: %v", err)
+	}
+	if identity != "alice@example.com" {
+		t.Fatalf("identity = %q, want %q", identity, "alice@example.com")
+	}
+	if sess.ID != "sid" {
+		t.Fatalf("session ID = %q, want %q", sess.ID, "sid")
+	}
+}
+
+func TestIsAllowedOAuthDomain(t *testing.T) {
+	cases := []struct {
+		identity string
+		allowed  []string
+		want     bool
+	}{
+		{"alice@example.com", nil, true},
+		{"alice@example.com", []string{"example.com"}, true},
+		{"alice@example.com", []string{"ExAmPlE.CoM"}, true},
+		{"alice@other.com", []string{"example.com"}, false},
+		{"not-an-email", []string{"example.com"}, false},
+	}
+	for _, c := range cases {
+		got := isAllowedOAuthDomain(c.identity, c.allowed)
+		if got != c.want {
+			t.Errorf("isAllowedOAuthDomain(%q, %v) = %v, want %v", c.identity, c.allowed, got, c.want)
+		}
+	}
+}
+
+func TestRandomOAuthTokenIsUnique(t *testing.T) {
+	a, err := randomOAuthToken()
+	if err != nil {
+		t.Fatalf("randomOAuthToken: %v", err)
+	}
+	b, err := randomOAuthToken()
+	if err != nil {
+		t.Fatalf("randomOAuthToken: %v", err)
+	}
+	if a == b {
+		t.Fatalf("expected two random tokens to differ")
+	}
+	if strings.Contains(a, " ") {
+		t.Fatalf("token %q should be URL-safe", a)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mailgun/ttlmap"
+)
+
+func TestSessionContextExpiredIdleTimeout(t *testing.T) {
+	now := time.Now()
+	ctx := &sessionContext{
+		createdAt:   now.Add(-time.Minute),
+		lastUsedAt:  now.Add(-2 * time.Minute),
+		IdleTimeout: time.Minute,
+	}
+	if !ctx.Expired(now) {
+		t.Fatalf("expected context idle for 2m with a 1m IdleTimeout to be expired")
+	}
+}
+
+func TestSessionContextNotExpiredWithinBudget(t *testing.T) {
+	now := time.Now()
+	ctx := &sessionContext{
+		createdAt:   now.Add(-time.Minute),
+		lastUsedAt:  now.Add(-time.Second),
+		IdleTimeout: time.Hour,
+		MaxLifetime: time.Hour,
+	}
+	if ctx.Expired(now) {
+		t.Fatalf("expected context within both budgets to not be expired")
+	}
+}
+
+func TestSessionContextExpiredMaxLifetime(t *testing.T) {
+	now := time.Now()
+	ctx := &sessionContext{
+		createdAt:   now.Add(-2 * time.Hour),
+		lastUsedAt:  now,
+		IdleTimeout: time.Hour,
+		MaxLifetime: time.Hour,
+	}
+	if !ctx.Expired(now) {
+		t.Fatalf("expected context created 2h ago with a 1h MaxLifetime to be expired even though it was just used")
+	}
+}
+
+func TestSessionContextZeroBudgetsNeverExpire(t *testing.T) {
+	now := time.Now()
+	ctx := &sessionContext{
+		createdAt:  now.Add(-24 * time.Hour),
+		lastUsedAt: now.Add(-24 * time.Hour),
+	}
+	if ctx.Expired(now) {
+		t.Fatalf("expected a context with no IdleTimeout/MaxLifetime configured to never expire")
+	}
+}
+
+func TestSessionContextRemainingLifetime(t *testing.T) {
+	now := time.Now()
+	ctx := &sessionContext{
+		createdAt:   now.Add(-40 * time.Minute),
+		MaxLifetime: time.Hour,
+	}
+	remaining := ctx.RemainingLifetime(now)
+	if remaining <= 0 || remaining > 20*time.Minute {
+		t.Fatalf("RemainingLifetime = %v, want roughly 20m", remaining)
+	}
+
+	ctx.createdAt = now.Add(-2 * time.Hour)
+	if got := ctx.RemainingLifetime(now); got != 0 {
+		t.Fatalf("RemainingLifetime past MaxLifetime = %v, want 0", got)
+	}
+
+	ctx.MaxLifetime = 0
+	if got := ctx.RemainingLifetime(now); got != 0 {
+		t.Fatalf("RemainingLifetime with no MaxLifetime = %v, want 0", got)
+	}
+}
+
+func newTestLifetimeCache(t *testing.T, idleTimeout, maxLifetime time.Duration) *sessionCache {
+	clients, err := ttlmap.NewMap(1024)
+	if err != nil {
+		t.Fatalf("ttlmap.NewMap: %v", err)
+	}
+	store, err := newTTLMapSessionStore(1024)
+	if err != nil {
+		t.Fatalf("newTTLMapSessionStore: %v", err)
+	}
+	return &sessionCache{
+		clients:     clients,
+		store:       store,
+		idleTimeout: idleTimeout,
+		maxLifetime: maxLifetime,
+	}
+}
+
+func TestTtlForClampsToMaxLifetime(t *testing.T) {
+	s := newTestLifetimeCache(t, 0, 5*time.Minute)
+	now := time.Now()
+	ctx := &sessionContext{createdAt: now.Add(-4 * time.Minute), MaxLifetime: 5 * time.Minute}
+
+	ttl := s.ttlFor(ctx, now)
+	if ttl > time.Minute {
+		t.Fatalf("ttlFor = %v, want <= 1m since only ~1m of MaxLifetime remains", ttl)
+	}
+}
+
+func TestTtlForClampsToIdleTimeout(t *testing.T) {
+	s := newTestLifetimeCache(t, time.Minute, time.Hour)
+	now := time.Now()
+	ctx := &sessionContext{
+		createdAt:   now,
+		lastUsedAt:  now.Add(-40 * time.Second),
+		IdleTimeout: time.Minute,
+		MaxLifetime: time.Hour,
+	}
+
+	ttl := s.ttlFor(ctx, now)
+	if ttl > 20*time.Second {
+		t.Fatalf("ttlFor = %v, want <= 20s since only ~20s of IdleTimeout remains, regardless of the much longer MaxLifetime budget", ttl)
+	}
+}
+
+func TestTouchContextRenewsUnexpiredSession(t *testing.T) {
+	s := newTestLifetimeCache(t, time.Hour, time.Hour)
+	now := time.Now()
+	ctx := &sessionContext{
+		user:        "alice",
+		createdAt:   now.Add(-time.Minute),
+		lastUsedAt:  now.Add(-30 * time.Minute),
+		IdleTimeout: time.Hour,
+		MaxLifetime: time.Hour,
+	}
+
+	expired, err := s.touchContext("alice", "sid-1", ctx, now)
+	if err != nil {
+		t.Fatalf("touchContext: %v", err)
+	}
+	if expired {
+		t.Fatalf("expected an active session within budget to not be reported expired")
+	}
+	if !ctx.lastUsedAt.Equal(now) {
+		t.Fatalf("touchContext did not bump lastUsedAt: got %v, want %v", ctx.lastUsedAt, now)
+	}
+	if _, ok, err := s.store.Get("alice" + "sid-1"); err != nil || !ok {
+		t.Fatalf("expected touchContext to record the session in the shared store, ok=%v err=%v", ok, err)
+	}
+}
+
+func TestTouchContextReportsIdleExpiry(t *testing.T) {
+	s := newTestLifetimeCache(t, time.Minute, time.Hour)
+	now := time.Now()
+	ctx := &sessionContext{
+		user:        "alice",
+		createdAt:   now.Add(-10 * time.Minute),
+		lastUsedAt:  now.Add(-5 * time.Minute),
+		IdleTimeout: time.Minute,
+		MaxLifetime: time.Hour,
+	}
+
+	expired, err := s.touchContext("alice", "sid-1", ctx, now)
+	if err != nil {
+		t.Fatalf("touchContext: %v", err)
+	}
+	if !expired {
+		t.Fatalf("expected a session idle for 5m with a 1m IdleTimeout to be reported expired")
+	}
+}
+
+// TestTouchContextConcurrentAccessIsRaceFree exercises the scenario
+// called out in review: two goroutines validating the same shared
+// *sessionContext concurrently must not race on lastUsedAt. Run with
+// -race to check.
+func TestTouchContextConcurrentAccessIsRaceFree(t *testing.T) {
+	s := newTestLifetimeCache(t, time.Hour, time.Hour)
+	now := time.Now()
+	ctx := &sessionContext{
+		user:        "alice",
+		createdAt:   now,
+		lastUsedAt:  now,
+		IdleTimeout: time.Hour,
+		MaxLifetime: time.Hour,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.touchContext("alice", "sid-1", ctx, time.Now()); err != nil {
+				t.Errorf("touchContext: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// renewSessionResponse is returned by /v1/webapi/sessions/renew so the
+// front-end can show a countdown and refresh proactively before the
+// session's cookie actually dies.
+type renewSessionResponse struct {
+	// ExpiresIn is the number of seconds left before MaxLifetime forces
+	// the session to end, or 0 if no MaxLifetime is configured.
+	ExpiresIn int `json:"expires_in"`
+}
+
+// newRenewSessionHandler validates the caller's session cookie,
+// bumps its idle timer and sliding TTL via ValidateSession, and
+// reports the remaining absolute lifetime.
+func newRenewSessionHandler(s *sessionCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			http.Error(w, "missing session cookie", http.StatusUnauthorized)
+			return
+		}
+		user, sid, err := DecodeCookie(cookie.Value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		ctx, err := s.ValidateSession(user, sid)
+		if err != nil {
+			log.Infof("failed to renew session for %v: %v", user, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err := s.SetSession(w, user, sid); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(renewSessionResponse{
+			ExpiresIn: int(ctx.RemainingLifetime(time.Now()) / time.Second),
+		})
+	}
+}
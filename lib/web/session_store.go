@@ -0,0 +1,113 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/mailgun/ttlmap"
+)
+
+// StoredSession is the minimal, re-creatable identity of a web
+// session: enough for any proxy process to rebuild a *sessionContext
+// (and a fresh *auth.TunClient) without holding the original
+// connection itself.
+type StoredSession struct {
+	User string
+	SID  string
+	// CreatedAt is when the session was first validated, preserved
+	// across proxies so MaxLifetime keeps counting from the original
+	// login rather than from whichever process last rebuilt it.
+	CreatedAt time.Time
+}
+
+// SessionStore persists the identity of active web sessions so it can
+// be shared between proxy processes sitting behind a load balancer.
+// It intentionally never stores the live auth server connection: that
+// stays in a per-process secondary cache keyed off the same entry.
+// Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Get returns the session stored under key, or ok=false if it is
+	// absent or has expired.
+	Get(key string) (sess StoredSession, ok bool, err error)
+	// Set stores sess under key for the given ttl, refreshing the ttl
+	// if the key already exists.
+	Set(key string, sess StoredSession, ttl time.Duration) error
+	// Delete removes key from the store.
+	Delete(key string) error
+	// OnExpire registers a callback invoked when a key expires from
+	// the store on its own, as opposed to being explicitly deleted.
+	// Implementations that cannot observe expiry (e.g. a backend
+	// polled by TTL) may treat this as a no-op.
+	OnExpire(fn func(key string))
+}
+
+// ttlMapSessionStore is the default, in-process SessionStore backed by
+// ttlmap.TtlMap. It is what sessionCache used directly before
+// SessionStore was introduced, and it does not survive a proxy
+// restart or share state with other proxies.
+type ttlMapSessionStore struct {
+	sync.Mutex
+	m        *ttlmap.TtlMap
+	onExpire func(key string)
+}
+
+// newTTLMapSessionStore returns a SessionStore with no cross-process
+// sharing, suitable for a single-proxy deployment.
+func newTTLMapSessionStore(capacity int) (*ttlMapSessionStore, error) {
+	s := &ttlMapSessionStore{}
+	m, err := ttlmap.NewMap(capacity, ttlmap.CallOnExpire(func(key string, val interface{}) {
+		// Delete leaves a nil placeholder behind to expire; its expiry is
+		// not a session expiring on its own, so it is not reported.
+		if s.onExpire != nil && val != nil {
+			s.onExpire(key)
+		}
+	}))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	s.m = m
+	return s, nil
+}
+
+func (s *ttlMapSessionStore) Get(key string) (StoredSession, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	val, ok := s.m.Get(key)
+	if !ok || val == nil {
+		return StoredSession{}, false, nil
+	}
+	return val.(StoredSession), true, nil
+}
+
+func (s *ttlMapSessionStore) Set(key string, sess StoredSession, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	return trace.Wrap(s.m.Set(key, sess, int(ttl/time.Second)))
+}
+
+func (s *ttlMapSessionStore) Delete(key string) error {
+	s.Lock()
+	defer s.Unlock()
+	return trace.Wrap(s.m.Set(key, nil, 1))
+}
+
+func (s *ttlMapSessionStore) OnExpire(fn func(key string)) {
+	s.onExpire = fn
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2015 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package web
+
+import (
+	"testing"
+	"time"
+)
+
+// Both SessionStore implementations must satisfy the interface; this
+// is a compile-time check rather than a runtime one.
+var (
+	_ SessionStore = (*ttlMapSessionStore)(nil)
+	_ SessionStore = (*backendSessionStore)(nil)
+)
+
+func TestTTLMapSessionStoreGetMissing(t *testing.T) {
+	store, err := newTTLMapSessionStore(1024)
+	if err != nil {
+		t.Fatalf("newTTLMapSessionStore: %v", err)
+	}
+	if _, ok, err := store.Get("nope"); err != nil || ok {
+		t.Fatalf("Get on missing key = (ok=%v, err=%v), want (false, nil)", ok, err)
+	}
+}
+
+func TestTTLMapSessionStoreSetGetRoundTrip(t *testing.T) {
+	store, err := newTTLMapSessionStore(1024)
+	if err != nil {
+		t.Fatalf("newTTLMapSessionStore: %v", err)
+	}
+	want := StoredSession{User: "alice", SID: "sid-1", CreatedAt: time.Now()}
+	if err := store.Set("alice"+"sid-1", want, time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	got, ok, err := store.Get("alice" + "sid-1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected stored session to be found")
+	}
+	if got.User != want.User || got.SID != want.SID {
+		t.Fatalf("Get returned %+v, want %+v", got, want)
+	}
+}
+
+func TestTTLMapSessionStoreDelete(t *testing.T) {
+	store, err := newTTLMapSessionStore(1024)
+	if err != nil {
+		t.Fatalf("newTTLMapSessionStore: %v", err)
+	}
+	key := "alice" + "sid-1"
+	if err := store.Set(key, StoredSession{User: "alice", SID: "sid-1"}, time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok, err := store.Get(key); err != nil || ok {
+		t.Fatalf("Get after Delete = (ok=%v, err=%v), want (false, nil)", ok, err)
+	}
+}
+
+func TestTTLMapSessionStoreOnExpire(t *testing.T) {
+	store, err := newTTLMapSessionStore(1024)
+	if err != nil {
+		t.Fatalf("newTTLMapSessionStore: %v", err)
+	}
+	expired := make(chan string, 1)
+	store.OnExpire(func(key string) {
+		expired <- key
+	})
+	key := "alice" + "sid-1"
+	if err := store.Set(key, StoredSession{User: "alice", SID: "sid-1"}, time.Second); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case got := <-expired:
+			if got != key {
+				t.Fatalf("OnExpire fired for %q, want %q", got, key)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+			// poke the map so a lazy-expiry implementation gets a
+			// chance to notice the key is past its ttl
+			store.Get(key)
+		}
+	}
+	t.Fatalf("OnExpire callback was never invoked")
+}
+
+// TestSessionStoreExpiryEvictsLocalClient checks the consumer wired up
+// in newSessionHandler: when the shared store's copy of a session
+// expires on its own, the matching entry in this process' local
+// clients cache is evicted too, rather than lingering on its own TTL.
+func TestSessionStoreExpiryEvictsLocalClient(t *testing.T) {
+	s, err := newSessionHandler(false, nil, nil, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("newSessionHandler: %v", err)
+	}
+
+	key := "alice" + "sid-1"
+	ctx := &sessionContext{user: "alice"}
+	if _, err := s.insertContext("alice", "sid-1", ctx, time.Second); err != nil {
+		t.Fatalf("insertContext: %v", err)
+	}
+	// give the local entry a much longer TTL than the store's, so only
+	// the store's expiry can evict it within the deadline below
+	s.Lock()
+	err = s.clients.Set(key, ctx, 3600)
+	s.Unlock()
+	if err != nil {
+		t.Fatalf("clients.Set: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.Lock()
+		val, ok := s.clients.Get(key)
+		s.Unlock()
+		if !ok || val == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		// poke the store so a lazy-expiry implementation gets a
+		// chance to notice the key is past its ttl and fire OnExpire
+		s.store.Get(key)
+	}
+	t.Fatalf("expected the local client to be evicted once the shared store entry expired")
+}
@@ -42,6 +42,45 @@ type sessionContext struct {
 	user   string
 	clt    *auth.TunClient
 	parent *sessionCache
+
+	// createdAt is when this context was first validated.
+	createdAt time.Time
+	// lastUsedAt is bumped on every successful ValidateSession call
+	// and is compared against IdleTimeout.
+	lastUsedAt time.Time
+	// IdleTimeout invalidates the context once time.Since(lastUsedAt)
+	// exceeds it, even though the underlying cache TTL hasn't expired.
+	IdleTimeout time.Duration
+	// MaxLifetime is the absolute cap on time.Since(createdAt): the
+	// context is never renewed past this point regardless of activity.
+	MaxLifetime time.Duration
+}
+
+// Expired reports whether ctx should no longer be treated as valid:
+// either the user has been idle for longer than IdleTimeout, or the
+// context has existed longer than MaxLifetime.
+func (c *sessionContext) Expired(now time.Time) bool {
+	if c.IdleTimeout > 0 && now.Sub(c.lastUsedAt) > c.IdleTimeout {
+		return true
+	}
+	if c.MaxLifetime > 0 && now.Sub(c.createdAt) > c.MaxLifetime {
+		return true
+	}
+	return false
+}
+
+// RemainingLifetime returns how long the context has left before
+// MaxLifetime forces it to expire, for surfacing a countdown to the
+// web UI. It returns 0 if MaxLifetime is unset.
+func (c *sessionContext) RemainingLifetime(now time.Time) time.Duration {
+	if c.MaxLifetime == 0 {
+		return 0
+	}
+	remaining := c.MaxLifetime - now.Sub(c.createdAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
 }
 
 func (c *sessionContext) Invalidate() error {
@@ -95,25 +134,66 @@ func (c *sessionContext) Close() error {
 	return nil
 }
 
-// newSessionHandler returns new instance of the session handler
-func newSessionHandler(secure bool, servers []utils.NetAddr) (*sessionCache, error) {
-	m, err := ttlmap.NewMap(1024, ttlmap.CallOnExpire(closeContext))
+// newSessionHandler returns new instance of the session handler.
+// store holds the shared, HA-safe record of which sessions are
+// active; it does not hold the live auth server connections, which
+// stay local to this process.
+func newSessionHandler(secure bool, servers []utils.NetAddr, oauthProviders []OAuthProvider, store SessionStore, adminClt krlAdminClient, idleTimeout, maxLifetime time.Duration) (*sessionCache, error) {
+	clients, err := ttlmap.NewMap(1024, ttlmap.CallOnExpire(closeContext))
 	if err != nil {
 		return nil, err
 	}
-	return &sessionCache{
-		contexts:    m,
-		authServers: servers,
-	}, nil
+	requests, err := ttlmap.NewMap(1024)
+	if err != nil {
+		return nil, err
+	}
+	providers := make(map[string]OAuthProvider, len(oauthProviders))
+	for _, p := range oauthProviders {
+		providers[p.Name] = p
+	}
+	if store == nil {
+		store, err = newTTLMapSessionStore(1024)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s := &sessionCache{
+		clients:        clients,
+		store:          store,
+		authServers:    servers,
+		oauthProviders: providers,
+		oauthRequests:  requests,
+		adminClt:       adminClt,
+		krl:            &krlCache{},
+		idleTimeout:    idleTimeout,
+		maxLifetime:    maxLifetime,
+	}
+	store.OnExpire(s.evictLocalClient)
+	return s, nil
 }
 
-// sessionCache handles web session authentication,
-// and holds in memory contexts associated with each session
+// sessionCache handles web session authentication. store is the
+// shared record of which sessions exist (so it can be backed by
+// Redis, etcd, BoltDB, ... and survive failover between proxies);
+// clients is a per-process cache of the live *auth.TunClient for
+// sessions this process has already validated.
 type sessionCache struct {
 	sync.Mutex
-	secure      bool
-	contexts    *ttlmap.TtlMap
-	authServers []utils.NetAddr
+	secure         bool
+	clients        *ttlmap.TtlMap
+	store          SessionStore
+	authServers    []utils.NetAddr
+	oauthProviders map[string]OAuthProvider
+	oauthRequests  *ttlmap.TtlMap
+	// oauthExchange overrides exchangeOAuthCode's call into the real
+	// identity provider/auth server when set; nil in production.
+	oauthExchange func(p OAuthProvider, code, nonce string) (string, *auth.Session, error)
+	adminClt      krlAdminClient
+	krl           *krlCache
+	// idleTimeout and maxLifetime are the defaults applied to every
+	// sessionContext this cache creates; see sessionContext.Expired.
+	idleTimeout time.Duration
+	maxLifetime time.Duration
 }
 
 // closeContext is called when session context expires from
@@ -149,7 +229,11 @@ func (s *sessionCache) GetCertificate(c createSSHCertReq) (*SSHLoginResponse, er
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	cert, err := clt.GenerateUserCert(c.PubKey, c.User, c.TTL)
+	// the auth server embeds a monotonically increasing serial in every
+	// cert it signs and hands it back here so the caller can later
+	// revoke this exact cert via RevokeUserCerts without having to
+	// parse it back out of the returned bytes.
+	cert, serial, err := clt.GenerateUserCert(c.PubKey, c.User, c.TTL)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -165,6 +249,7 @@ func (s *sessionCache) GetCertificate(c createSSHCertReq) (*SSHLoginResponse, er
 
 	return &SSHLoginResponse{
 		Cert:        cert,
+		CertSerial:  serial,
 		HostSigners: signers,
 	}, nil
 }
@@ -210,11 +295,15 @@ func (s *sessionCache) InvalidateSession(ctx *sessionContext) error {
 	return trace.Wrap(err)
 }
 
+// getContext returns the sessionContext for user+sid from this
+// process' local cache only. A miss here does not mean the session
+// doesn't exist: another proxy process may be holding it, so callers
+// should fall back to the shared store.
 func (s *sessionCache) getContext(user, sid string) (*sessionContext, error) {
 	s.Lock()
 	defer s.Unlock()
 
-	val, ok := s.contexts.Get(user + sid)
+	val, ok := s.clients.Get(user + sid)
 	if ok {
 		return val.(*sessionContext), nil
 	}
@@ -225,11 +314,14 @@ func (s *sessionCache) insertContext(user, sid string, ctx *sessionContext, ttl
 	s.Lock()
 	defer s.Unlock()
 
-	val, ok := s.contexts.Get(user + sid)
+	val, ok := s.clients.Get(user + sid)
 	if ok && val != nil { // nil means that we've just invalidated the context now and set it to nil in the cache
 		return val.(*sessionContext), trace.Wrap(&teleport.AlreadyExistsError{})
 	}
-	if err := s.contexts.Set(user+sid, ctx, int(ttl/time.Second)); err != nil {
+	if err := s.clients.Set(user+sid, ctx, int(ttl/time.Second)); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := s.store.Set(user+sid, StoredSession{User: user, SID: sid, CreatedAt: ctx.createdAt}, ttl); err != nil {
 		return nil, trace.Wrap(err)
 	}
 	return ctx, nil
@@ -238,15 +330,79 @@ func (s *sessionCache) insertContext(user, sid string, ctx *sessionContext, ttl
 func (s *sessionCache) resetContext(user, sid string) error {
 	s.Lock()
 	defer s.Unlock()
-	return trace.Wrap(s.contexts.Set(user+sid, nil, 1))
+	if err := s.store.Delete(user + sid); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(s.clients.Set(user+sid, nil, 1))
 }
 
+// evictLocalClient is registered as the shared store's OnExpire
+// callback. The store is the authoritative record of whether a
+// session still exists; if its copy of key has expired on its own
+// (as opposed to this process evicting it), any *auth.TunClient this
+// process is still holding locally for key is stale and should be
+// closed and dropped too, instead of lingering until its own,
+// possibly later, local TTL.
+func (s *sessionCache) evictLocalClient(key string) {
+	s.Lock()
+	val, ok := s.clients.Get(key)
+	if ok && val != nil {
+		s.clients.Set(key, nil, 1)
+	}
+	s.Unlock()
+	if !ok || val == nil {
+		return
+	}
+	ctx := val.(*sessionContext)
+	if err := ctx.Close(); err != nil {
+		log.Infof("failed to close locally cached client for expired session %v: %v", key, err)
+	}
+}
+
+// ValidateSession returns the sessionContext for user+sid, preferring
+// this process' local cache of live auth server connections. On a
+// local miss (a fresh process, an evicted entry, or failover to a
+// different proxy behind the load balancer) it first consults the
+// shared SessionStore: a hit there means the session already existed
+// (here or on another proxy), so its original createdAt is recovered
+// and MaxLifetime is enforced before any auth server round-trip is
+// made. A fresh *auth.TunClient is then rebuilt from sid, cached
+// locally again, and recorded in the store so the next proxy to see
+// this cookie can do the same.
 func (s *sessionCache) ValidateSession(user, sid string) (*sessionContext, error) {
+	now := time.Now()
 	ctx, err := s.getContext(user, sid)
 	if err == nil {
+		expired, err := s.touchContext(user, sid, ctx, now)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if expired {
+			ctx.Infof("session idle timeout or max lifetime exceeded, invalidating")
+			if err := s.InvalidateSession(ctx); err != nil {
+				return nil, trace.Wrap(err)
+			}
+			return nil, trace.Wrap(teleport.AccessDenied("session expired"))
+		}
 		ctx.Infof("got from cache")
 		return ctx, nil
 	}
+
+	createdAt := now
+	stored, ok, err := s.store.Get(user + sid)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if ok {
+		createdAt = stored.CreatedAt
+		if s.maxLifetime > 0 && now.Sub(createdAt) > s.maxLifetime {
+			if err := s.resetContext(user, sid); err != nil {
+				return nil, trace.Wrap(err)
+			}
+			return nil, trace.Wrap(teleport.AccessDenied("session expired"))
+		}
+	}
+
 	method, err := auth.NewWebSessionAuth(user, []byte(sid))
 	if err != nil {
 		return nil, trace.Wrap(err)
@@ -260,16 +416,20 @@ func (s *sessionCache) ValidateSession(user, sid string) (*sessionContext, error
 		return nil, trace.Wrap(err)
 	}
 	c := &sessionContext{
-		clt:    clt,
-		user:   user,
-		sess:   sess,
-		parent: s,
+		clt:         clt,
+		user:        user,
+		sess:        sess,
+		parent:      s,
+		createdAt:   createdAt,
+		lastUsedAt:  now,
+		IdleTimeout: s.idleTimeout,
+		MaxLifetime: s.maxLifetime,
 	}
 	c.Entry = log.WithFields(log.Fields{
 		"user": user,
 		"sess": sess.ID[:4],
 	})
-	out, err := s.insertContext(user, sid, c, auth.WebSessionTTL)
+	out, err := s.insertContext(user, sid, c, s.ttlFor(c, now))
 	if err != nil {
 		// this means that someone has just inserted the context, so
 		// close our extra context and return
@@ -283,6 +443,56 @@ func (s *sessionCache) ValidateSession(user, sid string) (*sessionContext, error
 	return out, nil
 }
 
+// ttlFor returns the cache TTL to use for ctx: the sliding
+// auth.WebSessionTTL window, clamped so the context never outlives
+// its MaxLifetime and never sits in the local cache past its
+// IdleTimeout. Without the IdleTimeout clamp an abandoned session
+// would keep its *auth.TunClient alive until the full TTL even though
+// Expired() would reject it on the very next request, so closeContext
+// wouldn't run and release the connection until much later than
+// IdleTimeout actually allows.
+func (s *sessionCache) ttlFor(ctx *sessionContext, now time.Time) time.Duration {
+	ttl := auth.WebSessionTTL
+	if ctx.MaxLifetime > 0 {
+		if remaining := ctx.RemainingLifetime(now); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	if ctx.IdleTimeout > 0 {
+		if remaining := ctx.IdleTimeout - now.Sub(ctx.lastUsedAt); remaining < ttl {
+			ttl = remaining
+		}
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl
+}
+
+// touchContext checks ctx's idle/max-lifetime budget and, if it is
+// still within it, bumps lastUsedAt and renews its position in the
+// local cache and shared store with a fresh TTL. The same
+// *sessionContext is handed out to every concurrent request for the
+// same user+sid, so reading Expired() and writing lastUsedAt both
+// happen here, under sessionCache's lock, instead of in the caller
+// where they would race with each other across goroutines.
+func (s *sessionCache) touchContext(user, sid string, ctx *sessionContext, now time.Time) (expired bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if ctx.Expired(now) {
+		return true, nil
+	}
+	ctx.lastUsedAt = now
+	ttl := s.ttlFor(ctx, now)
+	if err := s.clients.Set(user+sid, ctx, int(ttl/time.Second)); err != nil {
+		return false, trace.Wrap(err)
+	}
+	if err := s.store.Set(user+sid, StoredSession{User: user, SID: sid, CreatedAt: ctx.createdAt}, ttl); err != nil {
+		return false, trace.Wrap(err)
+	}
+	return false, nil
+}
+
 func (s *sessionCache) SetSession(w http.ResponseWriter, user, sid string) error {
 	d, err := EncodeCookie(user, sid)
 	if err != nil {